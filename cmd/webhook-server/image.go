@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2019 StackRox Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/go-logr/logr"
+
+	"github.com/steven-zou/admission-controller-webhook-demo/pkg/config"
+	"github.com/steven-zou/admission-controller-webhook-demo/pkg/metrics"
+)
+
+// hasExplicitDomain reports whether ref already names a registry domain, using the same
+// heuristic the Docker/containerd reference grammar uses: the first path segment is treated as
+// a domain if it contains a "." or a ":", or is exactly "localhost". Anything else (a bare
+// "busybox", a single-namespace "stevenzou/busybox") is completed against docker.io by
+// reference.ParseNormalizedNamed, and is therefore a candidate for our own rewrite rules.
+func hasExplicitDomain(ref string) bool {
+	first := strings.SplitN(ref, "/", 2)[0]
+	return strings.ContainsAny(first, ".:") || first == "localhost"
+}
+
+// setImage decides the final image reference a container should run with, and the name of the
+// image-pull secret (if any) that needs to be attached for it to be pullable.
+//
+// image is parsed with the distribution/reference grammar rather than ad-hoc string splitting,
+// so references with a port-only host (myregistry:5000/ns/img), a digest
+// (busybox@sha256:...), or both a tag and a digest are decomposed correctly instead of
+// misclassified. The path is always normalized (bare "busybox" becomes "library/busybox"), and a
+// missing tag defaults to "latest" - but an existing digest is never discarded.
+//
+// Precedence: an already-trusted (bypassed) domain is left alone; otherwise the first matching
+// rule from the loaded config wins; otherwise, if the reference didn't name a domain at all, it
+// is rewritten under the built-in fallback registry so the webhook keeps working without a
+// config file; otherwise the reference is left untouched.
+//
+// The third return value is the fully-normalized source reference (same domain as image, just
+// decomposed and re-rendered) - the mirror-on-admit path (mirror.go) needs a reference it can
+// pull from directly, without re-deriving normalization rules of its own.
+func setImage(reqLogger logr.Logger, namespace, image string) (string, string, string) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		// Not a reference we can make sense of; pass it through rather than risk mangling it.
+		reqLogger.Error(err, "could not parse image reference, leaving it untouched", "image", image)
+		return image, "", image
+	}
+	named = reference.TagNameOnly(named)
+
+	explicitDomain := hasExplicitDomain(image)
+	domain := reference.Domain(named)
+	normalizedSource := rebuildReference(named, domain)
+
+	var cfg *config.Config
+	if cfgWatcher != nil {
+		cfg = cfgWatcher.Get()
+	}
+
+	newDomain := domain
+	pullSecretName := ""
+
+	switch {
+	case cfg != nil && explicitDomain && cfg.Bypassed(domain):
+		// Already points at a trusted registry; leave it alone.
+	case cfg != nil && cfg.Match(namespace, named.Name()) != nil:
+		rule := cfg.Match(namespace, named.Name())
+		newDomain = rule.RewriteTo
+		pullSecretName = rule.PullSecretName()
+	case !explicitDomain:
+		newDomain = registry
+		// The actual secret name isn't known until resolveDefaultPullSecret has talked to
+		// Harbor (or fallen back to the static pullUser/pullSecret pair); applySecurityDefaults
+		// swaps this sentinel out for the resolved name.
+		pullSecretName = defaultPullSecretSentinel
+	}
+
+	if newDomain != domain {
+		metrics.ImageRewritesTotal.WithLabelValues(domain, newDomain).Inc()
+	}
+
+	return rebuildReference(named, newDomain), pullSecretName, normalizedSource
+}
+
+// rebuildReference renders named under newDomain, preserving its normalized path along with
+// whatever tag and/or digest it carries.
+func rebuildReference(named reference.Named, newDomain string) string {
+	rewritten := fmt.Sprintf("%s/%s", newDomain, reference.Path(named))
+
+	if tagged, ok := named.(reference.Tagged); ok {
+		rewritten = fmt.Sprintf("%s:%s", rewritten, tagged.Tag())
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		rewritten = fmt.Sprintf("%s@%s", rewritten, digested.Digest().String())
+	}
+
+	return rewritten
+}
@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2019 StackRox Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestSetImageFallsBackToRegistry(t *testing.T) {
+	cfgWatcher = nil
+
+	cases := map[string]string{
+		"busybox":                  "demo.goharbor.io/tars/library/busybox:latest",
+		"busybox:1.2":              "demo.goharbor.io/tars/library/busybox:1.2",
+		"stevenzou/busybox":        "demo.goharbor.io/tars/stevenzou/busybox:latest",
+		"stevenzou/busybox:latest": "demo.goharbor.io/tars/stevenzou/busybox:latest",
+	}
+
+	for in, want := range cases {
+		got, secret, _ := setImage(logr.Discard(), "default", in)
+		if got != want {
+			t.Errorf("setImage(%q) = %q, want %q", in, got, want)
+		}
+		if secret == "" {
+			t.Errorf("setImage(%q) returned no pull secret, want one", in)
+		}
+	}
+}
+
+func TestSetImageLeavesExplicitDomainsAlone(t *testing.T) {
+	cfgWatcher = nil
+
+	cases := map[string]string{
+		"localhost:5000/foo":               "localhost:5000/foo:latest",
+		"myregistry:5000/ns/img:v1":        "myregistry:5000/ns/img:v1",
+		"docker.io/library/busybox:latest": "docker.io/library/busybox:latest",
+	}
+
+	for in, want := range cases {
+		got, secret, _ := setImage(logr.Discard(), "default", in)
+		if got != want {
+			t.Errorf("setImage(%q) = %q, want %q", in, got, want)
+		}
+		if secret != "" {
+			t.Errorf("setImage(%q) returned pull secret %q, want none", in, secret)
+		}
+	}
+}
+
+func TestSetImagePreservesDigests(t *testing.T) {
+	cfgWatcher = nil
+
+	const digest = "sha256:2a03a6059f21e150ae84b0973863609494aad70f0a80eaeb64bddde8f1db5f9e"
+
+	got, _, _ := setImage(logr.Discard(), "default", "busybox@"+digest)
+	want := "demo.goharbor.io/tars/library/busybox@" + digest
+	if got != want {
+		t.Errorf("setImage with digest = %q, want %q", got, want)
+	}
+
+	got, _, _ = setImage(logr.Discard(), "default", "myregistry:5000/ns/img:v1@"+digest)
+	want = "myregistry:5000/ns/img:v1@" + digest
+	if got != want {
+		t.Errorf("setImage with host-port, tag and digest = %q, want %q", got, want)
+	}
+}
+
+func TestHasExplicitDomain(t *testing.T) {
+	cases := map[string]bool{
+		"busybox":                   false,
+		"stevenzou/busybox":         false,
+		"localhost:5000/foo":        true,
+		"localhost/foo":             true,
+		"myregistry:5000/ns/img":    true,
+		"docker.io/library/busybox": true,
+		"quay.io/ns/img":            true,
+	}
+
+	for in, want := range cases {
+		if got := hasExplicitDomain(in); got != want {
+			t.Errorf("hasExplicitDomain(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+/*
+Copyright (c) 2019 StackRox Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+)
+
+// logger is the webhook's structured logger. It starts out backed by a no-op implementation so
+// that code running before initLogger (or in tests that never call main) doesn't need a nil
+// check, and is replaced with a real zap-backed logger in main().
+var logger logr.Logger = logr.Discard()
+
+// initLogger replaces logger with one backed by zap, returning a flush function the caller should
+// defer so buffered log entries aren't lost on exit.
+func initLogger() (flush func(), err error) {
+	zl, err := zap.NewProduction()
+	if err != nil {
+		return nil, err
+	}
+
+	logger = zapr.NewLogger(zl)
+	return func() { _ = zl.Sync() }, nil
+}
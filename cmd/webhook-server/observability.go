@@ -0,0 +1,117 @@
+/*
+Copyright (c) 2019 StackRox Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/api/admission/v1beta1"
+
+	"github.com/steven-zou/admission-controller-webhook-demo/pkg/metrics"
+)
+
+// observedAdmit wraps admit with request-scoped structured logging and Prometheus metrics, so
+// every handler registered through admitFuncHandler gets the same instrumentation without
+// repeating it in applySecurityDefaults itself. operation labels the metrics/logs ("mutate").
+// The reqLogger built here is also what's passed to admit, so every log line applySecurityDefaults
+// and the functions it calls emit carries the same uid/namespace/kind/name fields.
+func observedAdmit(operation string, admit admitFunc) admitFunc {
+	return func(_ logr.Logger, req *v1beta1.AdmissionRequest) ([]patchOperation, error) {
+		reqLogger := logger.WithValues(
+			"operation", operation,
+			"uid", req.UID,
+			"namespace", req.Namespace,
+			"kind", req.Kind.Kind,
+			"name", req.Name,
+		)
+
+		start := time.Now()
+		patches, err := admit(reqLogger, req)
+		duration := time.Since(start)
+
+		result := "allowed"
+		if err != nil {
+			result = "error"
+		}
+		metrics.AdmissionRequestsTotal.WithLabelValues(operation, req.Resource.Resource, result).Inc()
+		metrics.AdmissionDuration.WithLabelValues(operation, req.Resource.Resource).Observe(duration.Seconds())
+
+		if err != nil {
+			reqLogger.Error(err, "admission request failed", "durationMs", duration.Milliseconds())
+		} else {
+			reqLogger.Info("admission request handled", "patches", len(patches), "durationMs", duration.Milliseconds())
+		}
+
+		return patches, err
+	}
+}
+
+// observedValidate is observedAdmit's counterpart for validateFunc: same request-scoped fields
+// and metrics, plus the allow/deny outcome and any warnings validatePolicy surfaced.
+func observedValidate(operation string, validate validateFunc) validateFunc {
+	return func(req *v1beta1.AdmissionRequest) (bool, string, []string, error) {
+		reqLogger := logger.WithValues(
+			"operation", operation,
+			"uid", req.UID,
+			"namespace", req.Namespace,
+			"kind", req.Kind.Kind,
+			"name", req.Name,
+		)
+
+		start := time.Now()
+		allowed, reason, warnings, err := validate(req)
+		duration := time.Since(start)
+
+		result := "allowed"
+		if err != nil {
+			result = "error"
+		} else if !allowed {
+			result = "denied"
+		}
+		metrics.AdmissionRequestsTotal.WithLabelValues(operation, req.Resource.Resource, result).Inc()
+		metrics.AdmissionDuration.WithLabelValues(operation, req.Resource.Resource).Observe(duration.Seconds())
+
+		switch {
+		case err != nil:
+			reqLogger.Error(err, "validation request failed", "durationMs", duration.Milliseconds())
+		case !allowed:
+			reqLogger.Info("validation request denied", "reason", reason, "durationMs", duration.Milliseconds())
+		default:
+			reqLogger.Info("validation request allowed", "warnings", warnings, "durationMs", duration.Milliseconds())
+		}
+
+		return allowed, reason, warnings, err
+	}
+}
+
+// serveMetrics exposes the Prometheus collectors registered throughout the webhook (this package
+// and pkg/harbor) on addr, on a plain-HTTP handler separate from the TLS admission endpoints -
+// the same split kube-state-metrics and most controllers use between a secured API and an
+// internal-only metrics port.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Printf("serving metrics on %s/metrics", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("metrics server exited: %v", err)
+	}
+}
@@ -18,16 +18,35 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
+	"strconv"
 
+	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/steven-zou/admission-controller-webhook-demo/pkg/harbor"
+	"github.com/steven-zou/admission-controller-webhook-demo/pkg/metrics"
 )
 
+// harborClient provisions per-namespace Harbor robot accounts when the webhook is started with
+// Harbor admin credentials (see -harbor-url / -harbor-credentials-dir in main.go). It is left nil
+// when no credentials are configured, in which case resolveDefaultPullSecret falls back to the
+// static pullUser/pullSecret pair for backward compatibility with existing deployments.
+var harborClient *harbor.Client
+
+// defaultHarborProject is the Harbor project the fallback rewrite rule (registry constant)
+// pushes/pulls against - the same "tars" project the static pullSecret JWT was originally
+// scoped to.
+const defaultHarborProject = "tars"
+
+// robotIDAnnotation records which Harbor robot account a pull secret was generated from, so
+// makeRobotSecret can tell an up-to-date secret from one that needs rotating.
+const robotIDAnnotation = "tars.goharbor.io/robot-id"
+
 type dockerAuth struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -39,11 +58,11 @@ type dockerAuths struct {
 	Auths map[string]*dockerAuth `json:"auths"`
 }
 
-func makeAuth(username, password string)[]byte{
+func makeAuth(registryURL, username, password string) []byte {
 	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s",username, password)))
 	dauth := &dockerAuths{
 		Auths: map[string]*dockerAuth {
-			"https://demo.goharbor.io/v2/":{
+			registryURL:{
 				Username: username,
 				Password: password,
 				Email: fmt.Sprintf("%s@goharbor.io", username),
@@ -71,7 +90,99 @@ func getClientSet() (*kubernetes.Clientset, error) {
 	return clientset, nil
 }
 
-func makeSecret(namespace string, user string, pass string) error {
+// resolveDefaultPullSecret provisions the pull secret backing the webhook's fallback rewrite
+// rule (images with no explicit domain, rewritten under the built-in registry constant) and
+// returns its name. When harborClient is configured it provisions a project-scoped robot account
+// dynamically; otherwise it falls back to the static pullUser/pullSecret pair for deployments
+// that haven't been given Harbor admin credentials yet.
+func resolveDefaultPullSecret(reqLogger logr.Logger, namespace string) (string, error) {
+	if harborClient == nil {
+		if err := makeStaticSecret(reqLogger, namespace, pullUser, pullSecret); err != nil {
+			return "", err
+		}
+		return formatName(pullUser), nil
+	}
+
+	return makeRobotSecret(reqLogger, namespace, defaultHarborProject)
+}
+
+// makeRobotSecret provisions (or reuses) a Harbor robot account for namespace and makes sure the
+// namespace's dockerconfigjson Secret reflects its current credentials, rotating the Secret via
+// Update when the robot's secret has changed since the Secret was last written.
+func makeRobotSecret(reqLogger logr.Logger, namespace, project string) (string, error) {
+	robot, err := harborClient.RobotFor(project, namespace)
+	if err != nil {
+		return "", fmt.Errorf("could not provision harbor robot account for %s/%s: %v", project, namespace, err)
+	}
+
+	clientset, err := getClientSet()
+	if err != nil {
+		return "", err
+	}
+
+	name := formatName(robot.Name)
+	authData := makeAuth("https://demo.goharbor.io/v2/", robot.Name, robot.Secret)
+	robotID := strconv.FormatInt(robot.ID, 10)
+
+	existing, err := clientset.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+				Labels: map[string]string{
+					"owner": "tars",
+				},
+				Annotations: map[string]string{
+					robotIDAnnotation: robotID,
+				},
+			},
+			Type: corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				".dockerconfigjson": authData,
+			},
+		}
+
+		created, err := clientset.CoreV1().Secrets(namespace).Create(secret)
+		if err != nil {
+			return "", err
+		}
+
+		metrics.PullSecretCreationsTotal.Inc()
+		reqLogger.Info("pull secret created", "secretNamespace", created.Namespace, "secretName", created.Name, "robot", robot.Name)
+		return name, nil
+	}
+
+	if existing.Annotations[robotIDAnnotation] == robotID && string(existing.Data[".dockerconfigjson"]) == string(authData) {
+		// Already up to date with the current robot credentials.
+		return name, nil
+	}
+
+	if existing.Annotations == nil {
+		existing.Annotations = map[string]string{}
+	}
+	existing.Annotations[robotIDAnnotation] = robotID
+	existing.Data = map[string][]byte{
+		".dockerconfigjson": authData,
+	}
+
+	if _, err := clientset.CoreV1().Secrets(namespace).Update(existing); err != nil {
+		return "", fmt.Errorf("could not rotate secret %s/%s: %v", namespace, name, err)
+	}
+
+	metrics.PullSecretCreationsTotal.Inc()
+	reqLogger.Info("pull secret rotated", "secretNamespace", namespace, "secretName", name, "robot", robot.Name)
+	return name, nil
+}
+
+// makeStaticSecret is the original behavior: it creates (but never rotates) a dockerconfigjson
+// Secret from a fixed username/password pair. It only still exists as the fallback for
+// deployments run without Harbor admin credentials configured; see resolveDefaultPullSecret.
+func makeStaticSecret(reqLogger logr.Logger, namespace string, user string, pass string) error {
 	clientset, err := getClientSet()
 	if err != nil {
 		return err
@@ -83,8 +194,7 @@ func makeSecret(namespace string, user string, pass string) error {
 			return err
 		}
 
-		authData := makeAuth(user, pass)
-		log.Printf("auth data=%s", string(authData))
+		authData := makeAuth("https://demo.goharbor.io/v2/", user, pass)
 
 		// create new
 		secret := &corev1.Secret{
@@ -106,7 +216,8 @@ func makeSecret(namespace string, user string, pass string) error {
 			return err
 		}
 
-		log.Printf("Secret %s:%s@%s created", createdSec.Namespace, createdSec.Name, createdSec.Type)
+		metrics.PullSecretCreationsTotal.Inc()
+		reqLogger.Info("pull secret created", "secretNamespace", createdSec.Namespace, "secretName", createdSec.Name, "secretType", createdSec.Type)
 	}
 
 	// do nothing
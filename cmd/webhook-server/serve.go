@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2019 StackRox Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+)
+
+var (
+	universalDeserializer = serializer.NewCodecFactory(runtime.NewScheme()).UniversalDeserializer()
+)
+
+// patchOperation is an object that represents a single patch operation to be applied as part of a
+// JSONPatch produced by a mutating webhook, per RFC 6902.
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// admitFunc is a callback for a mutating admission controller. It receives the request-scoped
+// logger built by observedAdmit (observability.go) alongside the AdmissionRequest, so the
+// per-request uid/namespace/kind/name fields end up attached to every log line a handler emits,
+// not just the summary line observedAdmit itself logs. It returns the patch operations to apply,
+// or an error if the request should be rejected.
+type admitFunc func(log logr.Logger, req *v1beta1.AdmissionRequest) ([]patchOperation, error)
+
+// doServeHTTP parses the AdmissionReview from body, invokes admit, and marshals the resulting
+// AdmissionResponse, including any JSONPatch the admit callback produced. The logger passed to
+// admit here is the package-level default; admit is always observedAdmit's wrapper in practice,
+// which builds and substitutes its own request-scoped logger before calling through.
+func doServeHTTP(body []byte, admit admitFunc) ([]byte, error) {
+	review := v1beta1.AdmissionReview{}
+	if _, _, err := universalDeserializer.Decode(body, nil, &review); err != nil {
+		return nil, fmt.Errorf("could not deserialize admission review: %v", err)
+	}
+	if review.Request == nil {
+		return nil, fmt.Errorf("admission review had no request")
+	}
+
+	response := v1beta1.AdmissionReview{}
+	response.SetGroupVersionKind(review.GroupVersionKind())
+	response.Response = &v1beta1.AdmissionResponse{UID: review.Request.UID}
+
+	patches, err := admit(logger, review.Request)
+	if err != nil {
+		log.Printf("admit error: %v", err)
+		response.Response.Allowed = false
+		response.Response.Result = &metav1.Status{Message: err.Error()}
+	} else {
+		response.Response.Allowed = true
+		if len(patches) > 0 {
+			patchBytes, err := json.Marshal(patches)
+			if err != nil {
+				return nil, fmt.Errorf("could not marshal JSON patch: %v", err)
+			}
+			response.Response.Patch = patchBytes
+			patchType := v1beta1.PatchTypeJSONPatch
+			response.Response.PatchType = &patchType
+		}
+	}
+
+	return json.Marshal(response)
+}
+
+// admitFuncHandler takes an admitFunc and wraps it into an http.Handler that reads the
+// AdmissionReview off the request body and writes the resulting AdmissionReview back.
+func admitFuncHandler(admit admitFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not read request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := doServeHTTP(body, admit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := w.Write(resp); err != nil {
+			log.Printf("could not write response: %v", err)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2019 StackRox Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer produces the spans applySecurityDefaults records around its deserialize/rewrite/
+// secret-provision/patch-build phases. Until initTracing installs a real SDK provider (see
+// -otel-exporter-endpoint), otel.Tracer returns a no-op implementation, so every span.End() below
+// is a harmless no-op rather than a nil check callers need to worry about.
+var tracer = otel.Tracer("admission-controller-webhook-demo")
+
+// initTracing wires a batching OTLP/gRPC exporter into the global TracerProvider when endpoint is
+// non-empty, so operators can point the webhook at a collector (e.g. "otel-collector:4317") to
+// debug slow admissions. It returns a shutdown function the caller should defer; when endpoint is
+// empty it returns a no-op shutdown and leaves the default (no-op) tracer in place.
+func initTracing(ctx context.Context, endpoint string) (shutdown func(context.Context) error, err error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("could not create otlp exporter: %v", err)
+	}
+
+	res, err := resource.New(ctx, resource.WithAttributes(
+		semconv.ServiceName("admission-controller-webhook-demo"),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("could not build otel resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	tracer = provider.Tracer("admission-controller-webhook-demo")
+
+	return provider.Shutdown, nil
+}
+
+// startSpan is a small convenience wrapper so the admission phases in main.go read as a single
+// line instead of repeating tracer.Start's signature everywhere.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name)
+}
@@ -0,0 +1,178 @@
+/*
+Copyright (c) 2019 StackRox Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/distribution/reference"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/steven-zou/admission-controller-webhook-demo/pkg/config"
+)
+
+// validateFunc validates an admission request. allowed reports whether the request should go
+// through; reason is the rejection message used when it doesn't. warnings are surfaced to the
+// caller regardless of whether the request was allowed, for near-miss cases that aren't worth
+// failing the request over.
+type validateFunc func(req *v1beta1.AdmissionRequest) (allowed bool, reason string, warnings []string, err error)
+
+// validateFuncHandler adapts a validateFunc into an http.Handler that speaks the AdmissionReview
+// wire protocol, the validating-webhook counterpart to admitFuncHandler.
+func validateFuncHandler(validate validateFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not read request body: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		review := v1beta1.AdmissionReview{}
+		if _, _, err := universalDeserializer.Decode(body, nil, &review); err != nil {
+			http.Error(w, fmt.Sprintf("could not deserialize admission review: %v", err), http.StatusBadRequest)
+			return
+		}
+		if review.Request == nil {
+			http.Error(w, "admission review had no request", http.StatusBadRequest)
+			return
+		}
+
+		response := v1beta1.AdmissionReview{}
+		response.SetGroupVersionKind(review.GroupVersionKind())
+		response.Response = &v1beta1.AdmissionResponse{UID: review.Request.UID}
+
+		allowed, reason, warnings, err := validate(review.Request)
+		if err != nil {
+			log.Printf("validate error: %v", err)
+			response.Response.Allowed = false
+			response.Response.Result = &metav1.Status{Message: err.Error()}
+		} else {
+			response.Response.Allowed = allowed
+			response.Response.Warnings = warnings
+			if !allowed {
+				response.Response.Result = &metav1.Status{Message: reason}
+			}
+		}
+
+		resp, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not marshal admission review: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := w.Write(resp); err != nil {
+			log.Printf("could not write response: %v", err)
+		}
+	})
+}
+
+// validatePolicy implements the validating half of the webhook. It runs after applySecurityDefaults
+// has already rewritten the pod's images, and enforces three things the mutating side only used
+// to comment on: that every (rewritten) image resolves to an allowed registry, that no container
+// combines runAsNonRoot=true with runAsUser=0, and, if configured, that the pod carries at least
+// one imagePullSecrets entry. Non-fatal observations (e.g. a mutable "latest" tag) are returned
+// as warnings instead of failing the request.
+func validatePolicy(req *v1beta1.AdmissionRequest) (bool, string, []string, error) {
+	specPointer, ok := podSpecResources[req.Resource]
+	if !ok {
+		// Not a resource we know how to find a PodSpec in; nothing to validate.
+		return true, "", nil, nil
+	}
+
+	spec, err := podSpecAt(req)
+	if err != nil {
+		return false, "", nil, err
+	}
+
+	var cfg *config.Config
+	if cfgWatcher != nil {
+		cfg = cfgWatcher.Get()
+	}
+
+	var warnings []string
+
+	for _, entry := range imageContainers(spec, specPointer) {
+		for _, c := range entry.containers {
+			if len(c.Image) == 0 {
+				continue
+			}
+
+			named, err := reference.ParseNormalizedNamed(c.Image)
+			if err != nil {
+				return false, fmt.Sprintf("container %q has an unparsable image %q: %v", c.Name, c.Image, err), warnings, nil
+			}
+
+			if cfg != nil && !cfg.Policy.AllowsRegistry(reference.Domain(named)) {
+				return false, fmt.Sprintf("container %q image %q resolves to registry %q, which is not in the allowed list", c.Name, c.Image, reference.Domain(named)), warnings, nil
+			}
+
+			if tagged, ok := named.(reference.Tagged); ok && tagged.Tag() == "latest" {
+				warnings = append(warnings, fmt.Sprintf("container %q uses the mutable \"latest\" tag", c.Name))
+			}
+		}
+	}
+
+	if cfg != nil && cfg.Policy.RequirePullSecrets && len(spec.ImagePullSecrets) == 0 {
+		return false, "pod has no imagePullSecrets entry", warnings, nil
+	}
+
+	if reason := securityContextConflict(spec); reason != "" {
+		return false, reason, warnings, nil
+	}
+
+	return true, "", warnings, nil
+}
+
+// securityContextConflict reports the first container (or the pod itself) whose securityContext
+// sets runAsNonRoot=true while also pinning runAsUser=0 - a configuration that can never actually
+// run, since UID 0 is root by definition. applySecurityDefaults has commented on this conflict
+// since the webhook's very first version; this is what actually enforces it.
+//
+// EphemeralContainers is checked alongside Containers/InitContainers so a pod added via the
+// pods/ephemeralcontainers subresource can't sidestep this check the way it could bypass image
+// rewriting before chunk0-1.
+func securityContextConflict(spec *corev1.PodSpec) string {
+	if sc := spec.SecurityContext; sc != nil && runAsNonRootConflictsWithUser(sc.RunAsNonRoot, sc.RunAsUser) {
+		return "pod securityContext sets runAsNonRoot=true but runAsUser=0"
+	}
+
+	for _, containers := range [][]corev1.Container{spec.Containers, spec.InitContainers} {
+		for _, c := range containers {
+			if sc := c.SecurityContext; sc != nil && runAsNonRootConflictsWithUser(sc.RunAsNonRoot, sc.RunAsUser) {
+				return fmt.Sprintf("container %q securityContext sets runAsNonRoot=true but runAsUser=0", c.Name)
+			}
+		}
+	}
+
+	for _, ec := range spec.EphemeralContainers {
+		if sc := ec.SecurityContext; sc != nil && runAsNonRootConflictsWithUser(sc.RunAsNonRoot, sc.RunAsUser) {
+			return fmt.Sprintf("ephemeral container %q securityContext sets runAsNonRoot=true but runAsUser=0", ec.Name)
+		}
+	}
+
+	return ""
+}
+
+func runAsNonRootConflictsWithUser(runAsNonRoot *bool, runAsUser *int64) bool {
+	return runAsNonRoot != nil && *runAsNonRoot && runAsUser != nil && *runAsUser == 0
+}
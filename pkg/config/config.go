@@ -0,0 +1,190 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config loads the webhook's rewrite rules and pull-secret references from a
+// ConfigMap-mounted YAML file, instead of the single hard-coded Harbor endpoint the webhook
+// used to ship with. It is deliberately dumb: it owns parsing and rule matching only, and
+// leaves watching the file for changes to Watcher.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rule describes one registry rewrite: any image whose domain matches Match is rewritten to
+// live under RewriteTo instead, using the pull secret named by PullSecretRef. A Rule only
+// applies to namespaces listed in Namespaces; an empty Namespaces matches every namespace.
+type Rule struct {
+	// Match is a glob pattern (e.g. "docker.io/*", "quay.io/*") matched against the image's
+	// domain and path. Use Regex instead for anything a glob can't express.
+	Match string `yaml:"match"`
+	// Regex, if set, is used instead of Match and is matched against the full image reference.
+	Regex string `yaml:"regex,omitempty"`
+	// RewriteTo is the registry/repository prefix the matched image is rewritten to.
+	RewriteTo string `yaml:"rewriteTo"`
+	// PullSecretRef names the pull secret (by the same convention as formatName) that should be
+	// attached to pods whose images this rule rewrites. Left empty, no imagePullSecrets entry is
+	// added for this rule at all - there is no secret the webhook can assume exists at the
+	// rewrite target, so it does not invent a name for one.
+	PullSecretRef string `yaml:"pullSecretRef,omitempty"`
+	// Namespaces restricts the rule to the listed namespaces. Empty means "all namespaces".
+	Namespaces []string `yaml:"namespaces,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// Config is the root of the webhook's configuration file, normally mounted at
+// /etc/webhook/config.yaml from a ConfigMap.
+type Config struct {
+	// Rules are evaluated in order; the first match wins.
+	Rules []Rule `yaml:"rules"`
+	// Bypass lists image domains (e.g. "demo.goharbor.io") that are already trusted and should
+	// be left untouched regardless of the rules above.
+	Bypass []string `yaml:"bypass,omitempty"`
+	// Policy configures the validating webhook. It is evaluated after the rules above have
+	// already rewritten the pod, so AllowedRegistries should list the rewrite targets, not the
+	// upstream registries the rules match against.
+	Policy Policy `yaml:"policy,omitempty"`
+}
+
+// Policy configures what the validating webhook enforces.
+type Policy struct {
+	// AllowedRegistries lists the domains a container's (post-mutation) image is allowed to
+	// resolve to. Empty means "don't enforce an allow-list".
+	AllowedRegistries []string `yaml:"allowedRegistries,omitempty"`
+	// RequirePullSecrets rejects pods that don't carry at least one imagePullSecrets entry.
+	RequirePullSecrets bool `yaml:"requirePullSecrets,omitempty"`
+}
+
+// AllowsRegistry reports whether domain is in the allow-list, or the allow-list is empty (i.e.
+// unconfigured, meaning every registry is allowed).
+func (p Policy) AllowsRegistry(domain string) bool {
+	if len(p.AllowedRegistries) == 0 {
+		return true
+	}
+
+	for _, allowed := range p.AllowedRegistries {
+		if allowed == domain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Load reads and parses the configuration file at path.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read webhook config %s: %v", path, err)
+	}
+
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("could not parse webhook config %s: %v", path, err)
+	}
+
+	for i := range cfg.Rules {
+		if cfg.Rules[i].Regex != "" {
+			re, err := regexp.Compile(cfg.Rules[i].Regex)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid regex %q: %v", i, cfg.Rules[i].Regex, err)
+			}
+			cfg.Rules[i].regex = re
+		}
+	}
+
+	return cfg, nil
+}
+
+// Bypassed reports whether image's domain is in the bypass list and should be left untouched.
+func (c *Config) Bypassed(domain string) bool {
+	for _, b := range c.Bypass {
+		if domain == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the first rule whose Namespaces allows namespace and whose Match/Regex matches
+// image, or nil if none apply.
+func (c *Config) Match(namespace, image string) *Rule {
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if !rule.allowsNamespace(namespace) {
+			continue
+		}
+
+		if rule.regex != nil {
+			if rule.regex.MatchString(image) {
+				return rule
+			}
+			continue
+		}
+
+		if matchGlob(rule.Match, image) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) allowsNamespace(namespace string) bool {
+	if len(r.Namespaces) == 0 {
+		return true
+	}
+
+	for _, ns := range r.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PullSecretName returns the pull secret that should back this rule, or "" if the rule doesn't
+// declare one (the same convention setImage uses for "no secret needed" elsewhere). It
+// deliberately does not fall back to the rewrite target's registry domain: nothing creates or
+// documents a Secret under that name, so guessing one would patch pods with an imagePullSecrets
+// entry pointing at an object that doesn't exist.
+func (r *Rule) PullSecretName() string {
+	return r.PullSecretRef
+}
+
+// matchGlob matches image against a shell-style glob pattern, using filepath.Match semantics
+// extended so "*" also matches path separators (registry globs routinely need to match across
+// "/", e.g. "docker.io/*").
+func matchGlob(pattern, image string) bool {
+	re := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+	matched, err := regexp.MatchString(re, image)
+	if err != nil {
+		return false
+	}
+	if matched {
+		return true
+	}
+
+	// Fall back to filepath.Match for patterns that don't need the "*" crosses "/" behavior.
+	ok, _ := filepath.Match(pattern, image)
+	return ok
+}
@@ -0,0 +1,163 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlobCrossesPathSeparators(t *testing.T) {
+	cases := []struct {
+		pattern, image string
+		want           bool
+	}{
+		{"docker.io/*", "docker.io/library/busybox", true},
+		{"docker.io/*", "docker.io/a/b/c", true},
+		{"quay.io/*", "docker.io/library/busybox", false},
+		{"*.example.com/*", "registry.example.com/team/app", true},
+		{"docker.io/library/busybox", "docker.io/library/busybox", true},
+	}
+
+	for _, c := range cases {
+		if got := matchGlob(c.pattern, c.image); got != c.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", c.pattern, c.image, got, c.want)
+		}
+	}
+}
+
+func TestConfigMatchFirstRuleWins(t *testing.T) {
+	cfg := &Config{
+		Rules: []Rule{
+			{Match: "docker.io/*", RewriteTo: "harbor.example.com/docker-proxy"},
+			{Match: "docker.io/library/*", RewriteTo: "harbor.example.com/library-only"},
+		},
+	}
+
+	rule := cfg.Match("default", "docker.io/library/busybox")
+	if rule == nil {
+		t.Fatal("expected a matching rule, got nil")
+	}
+	if rule.RewriteTo != "harbor.example.com/docker-proxy" {
+		t.Errorf("expected the first matching rule to win, got RewriteTo=%q", rule.RewriteTo)
+	}
+}
+
+func TestConfigMatchRespectsNamespaces(t *testing.T) {
+	cfg := &Config{
+		Rules: []Rule{
+			{Match: "quay.io/*", RewriteTo: "harbor.example.com/quay-proxy", Namespaces: []string{"team-a"}},
+		},
+	}
+
+	if rule := cfg.Match("team-a", "quay.io/app"); rule == nil {
+		t.Error("expected rule scoped to team-a to match in team-a")
+	}
+	if rule := cfg.Match("team-b", "quay.io/app"); rule != nil {
+		t.Error("expected rule scoped to team-a not to match in team-b")
+	}
+}
+
+func TestConfigMatchUsesRegexOverMatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	yaml := `
+rules:
+  - match: should-not-be-used/*
+    regex: '^quay\.io/(prod|staging)/.*$'
+    rewriteTo: harbor.example.com/quay-proxy
+`
+	if err := ioutil.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("could not write test config: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+
+	if cfg.Match("default", "quay.io/prod/app") == nil {
+		t.Error("expected regex rule to match quay.io/prod/app")
+	}
+	if cfg.Match("default", "quay.io/dev/app") != nil {
+		t.Error("expected regex rule not to match quay.io/dev/app")
+	}
+}
+
+func TestLoadRejectsInvalidRegex(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	yaml := `
+rules:
+  - regex: '('
+    rewriteTo: harbor.example.com/quay-proxy
+`
+	if err := ioutil.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("could not write test config: %v", err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Error("expected Load to reject an invalid regex, got nil error")
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := Load(filepath.Join(os.TempDir(), "does-not-exist-config.yaml")); err == nil {
+		t.Error("expected Load to return an error for a missing file")
+	}
+}
+
+func TestBypassed(t *testing.T) {
+	cfg := &Config{Bypass: []string{"demo.goharbor.io"}}
+
+	if !cfg.Bypassed("demo.goharbor.io") {
+		t.Error("expected demo.goharbor.io to be bypassed")
+	}
+	if cfg.Bypassed("docker.io") {
+		t.Error("expected docker.io not to be bypassed")
+	}
+}
+
+func TestRulePullSecretName(t *testing.T) {
+	withRef := Rule{RewriteTo: "harbor.example.com/quay-proxy", PullSecretRef: "quay-pull"}
+	if got := withRef.PullSecretName(); got != "quay-pull" {
+		t.Errorf("expected explicit PullSecretRef to be used, got %q", got)
+	}
+
+	// A rule with no PullSecretRef must not invent a secret name from RewriteTo: nothing creates
+	// or documents a Secret under that name, so patching it in would reference a Secret that
+	// doesn't exist.
+	noRef := Rule{RewriteTo: "harbor.example.com/quay-proxy"}
+	if got := noRef.PullSecretName(); got != "" {
+		t.Errorf("expected no PullSecretRef to yield an empty secret name, got %q", got)
+	}
+}
+
+func TestPolicyAllowsRegistry(t *testing.T) {
+	empty := Policy{}
+	if !empty.AllowsRegistry("anything.example.com") {
+		t.Error("expected an empty allow-list to allow every registry")
+	}
+
+	restricted := Policy{AllowedRegistries: []string{"harbor.example.com"}}
+	if !restricted.AllowsRegistry("harbor.example.com") {
+		t.Error("expected harbor.example.com to be allowed")
+	}
+	if restricted.AllowsRegistry("docker.io") {
+		t.Error("expected docker.io not to be allowed")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher holds the most recently loaded Config and keeps it fresh by watching its backing file
+// for changes. ConfigMap-mounted files are updated via a symlink swap (kubelet's atomic
+// projection), so we watch the containing directory rather than the file itself.
+type Watcher struct {
+	path string
+
+	mu  sync.RWMutex
+	cfg *Config
+}
+
+// NewWatcher loads path once and starts watching it for changes in the background. Callers
+// should use Get to read the current configuration; it is always safe for concurrent use.
+func NewWatcher(path string) (*Watcher, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{path: path, cfg: cfg}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		// Hot-reload is a nice-to-have; fall back to the config we already loaded rather than
+		// failing startup because inotify isn't available.
+		log.Printf("config: could not start fsnotify watcher, hot-reload disabled: %v", err)
+		return w, nil
+	}
+
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		log.Printf("config: could not watch %s, hot-reload disabled: %v", filepath.Dir(path), err)
+		return w, nil
+	}
+
+	go w.run(watcher)
+
+	return w, nil
+}
+
+func (w *Watcher) run(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// Don't filter on event.Name matching path exactly: a ConfigMap volume updates via
+			// a symlink swap of "..data" to a new "..TIMESTAMP" directory, so the literal path
+			// never itself generates an inotify event - only its containing directory does.
+			// Reload on any write-ish event seen in that directory instead.
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			cfg, err := Load(w.path)
+			if err != nil {
+				log.Printf("config: reload of %s failed, keeping previous config: %v", w.path, err)
+				continue
+			}
+
+			w.mu.Lock()
+			w.cfg = cfg
+			w.mu.Unlock()
+
+			log.Printf("config: reloaded %s (%d rules)", w.path, len(cfg.Rules))
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("config: watcher error: %v", err)
+		}
+	}
+}
+
+// Get returns the currently loaded configuration.
+func (w *Watcher) Get() *Config {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.cfg
+}
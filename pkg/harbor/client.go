@@ -0,0 +1,345 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package harbor provisions project-scoped Harbor robot accounts on demand, so the webhook can
+// hand out short-lived pull credentials instead of shipping a single static, eventually-expired
+// token baked into the binary.
+package harbor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steven-zou/admission-controller-webhook-demo/pkg/metrics"
+)
+
+// refreshSkew is how far ahead of a robot's real expiry we consider it stale, so callers always
+// get a token that is safe to use for at least this long.
+const refreshSkew = 10 * time.Minute
+
+// Robot is a Harbor robot account usable as registry pull credentials.
+type Robot struct {
+	ID        int64
+	Name      string
+	Secret    string
+	ExpiresAt time.Time
+}
+
+// Client talks to the Harbor v2 API as an admin user to create and refresh robot accounts, and
+// caches them in-memory keyed by (project, namespace) so repeated admissions for the same
+// namespace don't hit Harbor on every pod.
+type Client struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	cache    map[string]*Robot
+	keyLocks map[string]*sync.Mutex
+}
+
+// NewClient returns a Client authenticating to the Harbor instance at baseURL (e.g.
+// "https://demo.goharbor.io") as username/password, normally sourced from a mounted Secret.
+func NewClient(baseURL, username, password string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		username:   username,
+		password:   password,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		cache:      map[string]*Robot{},
+		keyLocks:   map[string]*sync.Mutex{},
+	}
+}
+
+// lockFor returns the per-(project,namespace) mutex used to serialize provisioning, creating it
+// on first use.
+func (c *Client) lockFor(key string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l, ok := c.keyLocks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		c.keyLocks[key] = l
+	}
+	return l
+}
+
+// RobotFor returns a project-scoped robot account for namespace, creating one (or refreshing an
+// existing one's secret) if none is cached yet or the cached one is near expiry.
+func (c *Client) RobotFor(project, namespace string) (*Robot, error) {
+	key := project + "/" + namespace
+
+	c.mu.Lock()
+	cached := c.cache[key]
+	c.mu.Unlock()
+
+	if cached != nil && time.Now().Before(cached.ExpiresAt.Add(-refreshSkew)) {
+		return cached, nil
+	}
+
+	// Serialize provisioning per key, not just the cache read/write around it: two callers
+	// racing on a cold cache for the same (project, namespace) would otherwise both call
+	// provisionRobot, and the loser's createRobot 409s into refreshRobotSecret, force-rotating
+	// the winner's secret out from under it.
+	keyLock := c.lockFor(key)
+	keyLock.Lock()
+	defer keyLock.Unlock()
+
+	c.mu.Lock()
+	cached = c.cache[key]
+	c.mu.Unlock()
+	if cached != nil && time.Now().Before(cached.ExpiresAt.Add(-refreshSkew)) {
+		return cached, nil
+	}
+
+	robot, err := c.provisionRobot(project, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.cache[key] = robot
+	c.mu.Unlock()
+
+	return robot, nil
+}
+
+// robotName is deterministic per (project, namespace) so repeated calls find the same account
+// instead of piling up a new robot per admission.
+func robotName(namespace string) string {
+	return fmt.Sprintf("ns-%s", namespace)
+}
+
+type robotCreateRequest struct {
+	Name        string            `json:"name"`
+	Duration    int               `json:"duration"`
+	Level       string            `json:"level"`
+	Permissions []robotPermission `json:"permissions"`
+}
+
+type robotPermission struct {
+	Kind      string              `json:"kind"`
+	Namespace string              `json:"namespace"`
+	Access    []robotAccessPolicy `json:"access"`
+}
+
+type robotAccessPolicy struct {
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+}
+
+type robotCreateResponse struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+}
+
+type robotListEntry struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func (c *Client) provisionRobot(project, namespace string) (*Robot, error) {
+	name := robotName(namespace)
+
+	created, err := c.createRobot(project, name)
+	if err == errRobotExists {
+		existing, findErr := c.findRobot(project, name)
+		if findErr != nil {
+			return nil, findErr
+		}
+		return c.refreshRobotSecret(project, existing.ID, existing.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c.robotFromSecret(created.ID, created.Name, created.Secret)
+}
+
+var errRobotExists = fmt.Errorf("robot account already exists")
+
+func (c *Client) createRobot(project, name string) (*robotCreateResponse, error) {
+	body := robotCreateRequest{
+		Name:     name,
+		Duration: -1,
+		Level:    "project",
+		Permissions: []robotPermission{
+			{
+				Kind:      "project",
+				Namespace: project,
+				Access: []robotAccessPolicy{
+					{Resource: "repository", Action: "pull"},
+					{Resource: "repository", Action: "push"},
+				},
+			},
+		},
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(http.MethodPost, "/api/v2.0/robots", data)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return nil, errRobotExists
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("harbor: creating robot %q returned %s", name, resp.Status)
+	}
+
+	created := &robotCreateResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(created); err != nil {
+		return nil, fmt.Errorf("harbor: could not decode robot creation response: %v", err)
+	}
+
+	return created, nil
+}
+
+func (c *Client) findRobot(project, name string) (*robotListEntry, error) {
+	resp, err := c.do(http.MethodGet, fmt.Sprintf("/api/v2.0/robots?q=name%%3D%s", name), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("harbor: listing robots for project %q returned %s", project, resp.Status)
+	}
+
+	var entries []robotListEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("harbor: could not decode robot list response: %v", err)
+	}
+
+	for _, e := range entries {
+		if e.Name == name || strings.HasSuffix(e.Name, "$"+name) {
+			return &e, nil
+		}
+	}
+
+	return nil, fmt.Errorf("harbor: robot %q not found in project %q", name, project)
+}
+
+func (c *Client) refreshRobotSecret(project string, id int64, name string) (*Robot, error) {
+	resp, err := c.do(http.MethodPatch, fmt.Sprintf("/api/v2.0/robots/%d", id), []byte(`{"secret":""}`))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("harbor: refreshing robot %q (id %d) returned %s", name, id, resp.Status)
+	}
+
+	refreshed := &robotCreateResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(refreshed); err != nil {
+		return nil, fmt.Errorf("harbor: could not decode robot refresh response: %v", err)
+	}
+
+	return c.robotFromSecret(id, name, refreshed.Secret)
+}
+
+func (c *Client) robotFromSecret(id int64, name, secret string) (*Robot, error) {
+	expiresAt, err := jwtExpiry(secret)
+	if err != nil {
+		// Harbor is expected to hand back a JWT secret; if it doesn't, treat the token as
+		// non-expiring rather than failing the admission over something cosmetic.
+		expiresAt = time.Now().Add(24 * time.Hour)
+	}
+
+	return &Robot{ID: id, Name: name, Secret: secret, ExpiresAt: expiresAt}, nil
+}
+
+func (c *Client) do(method, path string, body []byte) (*http.Response, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(c.username, c.password)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// Status-code-level failures (4xx/5xx) are surfaced through each caller's own "returned
+		// %s" error instead, since only this transport layer can tell Harbor was unreachable at
+		// all rather than merely unhappy with the request.
+		metrics.HarborAPIErrorsTotal.Inc()
+	}
+	return resp, err
+}
+
+// jwtExpiry decodes the "exp" claim out of a JWT without verifying its signature - all we need
+// is to know when to proactively refresh, and Harbor itself is the source of truth for validity.
+func jwtExpiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not decode JWT payload: %v", err)
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("could not parse JWT claims: %v", err)
+	}
+	if claims.Exp == 0 {
+		return time.Time{}, fmt.Errorf("JWT had no exp claim")
+	}
+
+	return time.Unix(claims.Exp, 0), nil
+}
+
+// ReadCredentialsFile reads "username" and "password" files out of dir, the conventional layout
+// for a Kubernetes Secret mounted as a volume.
+func ReadCredentialsFile(dir string) (username, password string, err error) {
+	u, err := ioutil.ReadFile(dir + "/username")
+	if err != nil {
+		return "", "", fmt.Errorf("could not read harbor admin username: %v", err)
+	}
+	p, err := ioutil.ReadFile(dir + "/password")
+	if err != nil {
+		return "", "", fmt.Errorf("could not read harbor admin password: %v", err)
+	}
+
+	return strings.TrimSpace(string(u)), strings.TrimSpace(string(p)), nil
+}
@@ -0,0 +1,174 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package harbor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHarbor is a minimal stand-in for the handful of Harbor v2 API endpoints the client uses:
+// creating a robot, listing robots by name, and refreshing a robot's secret.
+type fakeHarbor struct {
+	robots map[string]*robotCreateResponse
+	nextID int64
+}
+
+func newFakeHarbor() *httptest.Server {
+	f := &fakeHarbor{robots: map[string]*robotCreateResponse{}}
+	return httptest.NewServer(http.HandlerFunc(f.handle))
+}
+
+func (f *fakeHarbor) handle(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v2.0/robots":
+		var req robotCreateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if _, exists := f.robots[req.Name]; exists {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		f.nextID++
+		robot := &robotCreateResponse{ID: f.nextID, Name: fmt.Sprintf("robot$%s", req.Name), Secret: fakeJWT(time.Now().Add(time.Hour))}
+		f.robots[req.Name] = robot
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(robot)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v2.0/robots":
+		name := r.URL.Query().Get("q")
+		// q comes in as "name=ns-foo"
+		var entries []robotListEntry
+		for key, robot := range f.robots {
+			if "name="+key == name {
+				entries = append(entries, robotListEntry{ID: robot.ID, Name: robot.Name})
+			}
+		}
+		json.NewEncoder(w).Encode(entries)
+	case r.Method == http.MethodPatch:
+		for key, robot := range f.robots {
+			path := fmt.Sprintf("/api/v2.0/robots/%d", robot.ID)
+			if r.URL.Path == path {
+				robot.Secret = fakeJWT(time.Now().Add(time.Hour))
+				f.robots[key] = robot
+				json.NewEncoder(w).Encode(robot)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func fakeJWT(exp time.Time) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix())))
+	return header + "." + payload + ".sig"
+}
+
+func TestRobotForCreatesAndCaches(t *testing.T) {
+	server := newFakeHarbor()
+	defer server.Close()
+
+	client := NewClient(server.URL, "admin", "Harbor12345")
+
+	robot, err := client.RobotFor("tars", "default")
+	if err != nil {
+		t.Fatalf("RobotFor returned error: %v", err)
+	}
+	if robot.Name == "" || robot.Secret == "" {
+		t.Fatalf("expected a populated robot, got %+v", robot)
+	}
+
+	again, err := client.RobotFor("tars", "default")
+	if err != nil {
+		t.Fatalf("second RobotFor returned error: %v", err)
+	}
+	if again.Secret != robot.Secret {
+		t.Errorf("expected cached robot to be reused, got a different secret")
+	}
+}
+
+func TestRobotForReusesExistingRobotOnConflict(t *testing.T) {
+	server := newFakeHarbor()
+	defer server.Close()
+
+	client := NewClient(server.URL, "admin", "Harbor12345")
+
+	first, err := client.RobotFor("tars", "default")
+	if err != nil {
+		t.Fatalf("RobotFor returned error: %v", err)
+	}
+
+	// Force a fresh client (empty cache) to exercise the "robot already exists in Harbor, reuse
+	// and refresh it" path rather than the in-memory cache.
+	client2 := NewClient(server.URL, "admin", "Harbor12345")
+	second, err := client2.RobotFor("tars", "default")
+	if err != nil {
+		t.Fatalf("RobotFor on a fresh client returned error: %v", err)
+	}
+
+	if second.Name != first.Name {
+		t.Errorf("expected the same robot to be reused, got %q vs %q", second.Name, first.Name)
+	}
+	if second.Secret == first.Secret {
+		t.Errorf("expected reusing an existing robot to refresh its secret")
+	}
+}
+
+func TestRobotForSerializesConcurrentProvisioning(t *testing.T) {
+	server := newFakeHarbor()
+	defer server.Close()
+
+	client := NewClient(server.URL, "admin", "Harbor12345")
+
+	const concurrency = 10
+	results := make([]*Robot, concurrency)
+	errs := make([]error, concurrency)
+
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		i := i
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = client.RobotFor("tars", "default")
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("RobotFor[%d] returned error: %v", i, err)
+		}
+	}
+
+	// With provisioning properly serialized on a cold cache, every concurrent caller should
+	// observe the same robot and secret - never a second caller's 409-triggered refresh racing
+	// the first caller's create.
+	for i := 1; i < concurrency; i++ {
+		if results[i].Secret != results[0].Secret {
+			t.Errorf("expected all concurrent RobotFor calls to observe one provisioned secret, got a mismatch at index %d", i)
+		}
+	}
+}
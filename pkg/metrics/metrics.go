@@ -0,0 +1,60 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics holds the webhook's Prometheus collectors. It is a standalone package (rather
+// than living in cmd/webhook-server) so pkg/harbor can record its own metrics without importing
+// the command package.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// AdmissionRequestsTotal counts every admission request the mutating or validating handler
+// processed, by operation ("mutate"/"validate"), resource and outcome.
+var AdmissionRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "webhook_admission_requests_total",
+	Help: "Total number of admission requests processed, by operation, resource and result.",
+}, []string{"operation", "resource", "result"})
+
+// AdmissionDuration tracks how long each admission request took to handle, so slow admissions
+// show up as a shift in the distribution rather than only in the logs.
+var AdmissionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "webhook_admission_duration_seconds",
+	Help:    "Time taken to handle an admission request, by operation and resource.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation", "resource"})
+
+// ImageRewritesTotal counts every container image setImage actually rewrote, by the registry it
+// was rewritten from and to - distinct from AdmissionRequestsTotal, since a single admission can
+// rewrite zero, one, or many container images.
+var ImageRewritesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "image_rewrites_total",
+	Help: "Total number of container image references rewritten, by source and target registry.",
+}, []string{"source_registry", "target_registry"})
+
+// PullSecretCreationsTotal counts every time the webhook created or rotated a namespace's
+// image-pull Secret, whether backed by a Harbor robot account or the static fallback credentials.
+var PullSecretCreationsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "pull_secret_creations_total",
+	Help: "Total number of image-pull Secrets created or rotated.",
+})
+
+// HarborAPIErrorsTotal counts failed calls to the Harbor API, so operators can tell a slow
+// admission caused by Harbor being unreachable apart from one caused by Kubernetes itself.
+var HarborAPIErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "harbor_api_errors_total",
+	Help: "Total number of Harbor API calls that failed outright (transport-level errors).",
+})
@@ -0,0 +1,86 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mirror
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+)
+
+// HarborCopier copies images using containers/image, authenticating the destination (and, if the
+// source also requires it, the source too) with the job's Auth.
+type HarborCopier struct {
+	// SourceAuth, when set, is used for every source regardless of the job's own Auth - the
+	// webhook's rewrite rules typically pull from public registries that need no credentials,
+	// but a deployment mirroring from a private upstream can set this.
+	SourceAuth *Auth
+}
+
+// Copy implements Copier.
+func (h *HarborCopier) Copy(ctx context.Context, job Job) (string, error) {
+	srcRef, err := docker.ParseReference("//" + job.Source)
+	if err != nil {
+		return "", fmt.Errorf("could not parse source reference %q: %v", job.Source, err)
+	}
+
+	destRef, err := docker.ParseReference("//" + job.Target)
+	if err != nil {
+		return "", fmt.Errorf("could not parse target reference %q: %v", job.Target, err)
+	}
+
+	policyCtx, err := signature.NewPolicyContext(&signature.Policy{
+		Default: signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()},
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not build signature policy: %v", err)
+	}
+	defer policyCtx.Destroy()
+
+	sourceCtx := &types.SystemContext{}
+	if h.SourceAuth != nil {
+		sourceCtx.DockerAuthConfig = &types.DockerAuthConfig{
+			Username: h.SourceAuth.Username,
+			Password: h.SourceAuth.Password,
+		}
+	}
+
+	destCtx := &types.SystemContext{
+		DockerAuthConfig: &types.DockerAuthConfig{
+			Username: job.Auth.Username,
+			Password: job.Auth.Password,
+		},
+	}
+
+	manifestBytes, err := copy.Image(ctx, policyCtx, destRef, srcRef, &copy.Options{
+		SourceCtx:      sourceCtx,
+		DestinationCtx: destCtx,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := manifest.Digest(manifestBytes)
+	if err != nil {
+		return "", fmt.Errorf("could not compute digest of copied manifest for %s: %v", job.Target, err)
+	}
+
+	return digest.String(), nil
+}
@@ -0,0 +1,83 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/types"
+)
+
+// pollInterval is how often ManifestExists re-checks the target registry while waiting.
+const pollInterval = 2 * time.Second
+
+// ManifestExists reports whether target's manifest is already resolvable, trying once.
+func ManifestExists(ctx context.Context, target string, auth Auth) (bool, error) {
+	ref, err := docker.ParseReference("//" + target)
+	if err != nil {
+		return false, fmt.Errorf("could not parse target reference %q: %v", target, err)
+	}
+
+	sysCtx := &types.SystemContext{
+		DockerAuthConfig: &types.DockerAuthConfig{
+			Username: auth.Username,
+			Password: auth.Password,
+		},
+	}
+
+	src, err := ref.NewImageSource(ctx, sysCtx)
+	if err != nil {
+		// Most not-found errors surface here rather than as a typed error, since they come
+		// from an HTTP 404 against the registry's manifest endpoint.
+		return false, nil
+	}
+	defer src.Close()
+
+	if _, _, err := src.GetManifest(ctx, nil); err != nil {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// WaitForManifest polls ManifestExists until it returns true or timeout elapses, for the
+// strict-mode mirror-on-admit path: admission blocks here, and is rejected if the target never
+// shows up within the deadline.
+func WaitForManifest(ctx context.Context, target string, auth Auth, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		ok, err := ManifestExists(ctx, target, auth)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("mirror: target manifest %s did not appear within %s", target, timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
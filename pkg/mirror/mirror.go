@@ -0,0 +1,173 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mirror pre-pulls images the webhook rewrote into Harbor, so the rewritten reference is
+// already resolvable by the time the kubelet tries to pull it - the same idea as
+// kube-image-keeper's local registry cache, applied at admission time instead of at pull time.
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Mode controls whether, and how strictly, admission waits on a mirror copy.
+type Mode string
+
+const (
+	// Off disables mirroring entirely; setImage's rewrite is the only thing that happens.
+	Off Mode = "off"
+	// Async enqueues a copy and admits the pod immediately; the rewritten image may 404 until
+	// the copy finishes.
+	Async Mode = "async"
+	// Strict blocks admission on the copy completing (polling the target manifest, up to a
+	// timeout) and rejects the pod if it doesn't show up in time.
+	Strict Mode = "strict"
+)
+
+// Auth is the registry credential pair used both to read the source image and to push/poll the
+// target, normally the same Harbor robot account the pull secret was built from.
+type Auth struct {
+	Username string
+	Password string
+}
+
+// Job is one source -> target copy request.
+type Job struct {
+	Source string
+	Target string
+	Auth   Auth
+}
+
+// Copier performs the actual image copy, returning the digest of the manifest it copied so
+// callers can record what's actually sitting at the target reference. HarborCopier (copier.go)
+// implements this with containers/image/v5/copy; tests use a fake.
+type Copier interface {
+	Copy(ctx context.Context, job Job) (digest string, err error)
+}
+
+// Store persists the status of images the queue has already copied (or attempted to), keyed by
+// the target reference, so the webhook doesn't re-copy on every single pod admission. See
+// store.go for the CachedImage-backed implementation.
+type Store interface {
+	Get(target string) (digest string, ok bool, err error)
+	Save(target, digest string, err error) error
+}
+
+// Queue dedups and fans out copy jobs to a fixed pool of workers, so a burst of pods referencing
+// the same image only triggers one copy.
+type Queue struct {
+	copier Copier
+	store  Store
+	jobs   chan Job
+
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+// NewQueue starts workers goroutines draining the queue. bufferSize bounds how many distinct
+// copy jobs can be outstanding before Enqueue starts dropping duplicates silently (an already
+// in-flight job for the same target is always deduped regardless of buffer size).
+func NewQueue(copier Copier, store Store, workers, bufferSize int) *Queue {
+	q := &Queue{
+		copier:  copier,
+		store:   store,
+		jobs:    make(chan Job, bufferSize),
+		pending: map[string]bool{},
+	}
+
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+
+	return q
+}
+
+// Enqueue schedules job for an async copy unless the target is already cached with a matching
+// digest, or a copy for this exact target is already in flight.
+func (q *Queue) Enqueue(job Job) {
+	if job.Source == job.Target {
+		return
+	}
+
+	q.mu.Lock()
+	if q.pending[job.Target] {
+		q.mu.Unlock()
+		return
+	}
+	q.pending[job.Target] = true
+	q.mu.Unlock()
+
+	select {
+	case q.jobs <- job:
+	default:
+		log.Printf("mirror: queue full, dropping copy of %s -> %s", job.Source, job.Target)
+		q.mu.Lock()
+		delete(q.pending, job.Target)
+		q.mu.Unlock()
+	}
+}
+
+func (q *Queue) worker() {
+	for job := range q.jobs {
+		q.run(job)
+	}
+}
+
+func (q *Queue) run(job Job) {
+	defer func() {
+		q.mu.Lock()
+		delete(q.pending, job.Target)
+		q.mu.Unlock()
+	}()
+
+	if q.store != nil {
+		if _, ok, err := q.store.Get(job.Target); err == nil && ok {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	digest, err := q.copier.Copy(ctx, job)
+	if err != nil {
+		log.Printf("mirror: copy of %s -> %s failed: %v", job.Source, job.Target, err)
+	} else {
+		log.Printf("mirror: copied %s -> %s (%s)", job.Source, job.Target, digest)
+	}
+
+	if q.store != nil {
+		if saveErr := q.store.Save(job.Target, digest, err); saveErr != nil {
+			log.Printf("mirror: could not persist CachedImage status for %s: %v", job.Target, saveErr)
+		}
+	}
+}
+
+// RunStrict performs job synchronously, for Strict mode: it kicks off the copy and then blocks
+// admission until the target manifest is actually resolvable (or timeout elapses), returning an
+// error that should fail admission if it never shows up.
+func RunStrict(copier Copier, job Job, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if _, err := copier.Copy(ctx, job); err != nil {
+		return fmt.Errorf("mirror: strict copy of %s -> %s failed: %v", job.Source, job.Target, err)
+	}
+
+	return WaitForManifest(ctx, job.Target, job.Auth, timeout)
+}
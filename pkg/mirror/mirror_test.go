@@ -0,0 +1,167 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCopier is a Copier whose Copy blocks until unblock is closed (if set), counts its calls
+// per target, and returns whatever digest/err the test configured.
+type fakeCopier struct {
+	unblock chan struct{}
+	digest  string
+	err     error
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newFakeCopier() *fakeCopier {
+	return &fakeCopier{calls: map[string]int{}}
+}
+
+func (f *fakeCopier) Copy(ctx context.Context, job Job) (string, error) {
+	if f.unblock != nil {
+		<-f.unblock
+	}
+
+	f.mu.Lock()
+	f.calls[job.Target]++
+	f.mu.Unlock()
+
+	return f.digest, f.err
+}
+
+func (f *fakeCopier) callCount(target string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[target]
+}
+
+// fakeStore is a Store that never actually persists anything unless preloaded, for exercising
+// Queue's "already cached" skip path independent of CRDStore/the dynamic client.
+type fakeStore struct {
+	mu       sync.Mutex
+	digests  map[string]string
+	savedErr error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{digests: map[string]string{}}
+}
+
+func (s *fakeStore) Get(target string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	digest, ok := s.digests[target]
+	return digest, ok, nil
+}
+
+func (s *fakeStore) Save(target, digest string, copyErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if copyErr == nil {
+		s.digests[target] = digest
+	}
+	return s.savedErr
+}
+
+func TestQueueDedupesInFlightJobsForSameTarget(t *testing.T) {
+	copier := newFakeCopier()
+	copier.unblock = make(chan struct{})
+
+	q := NewQueue(copier, nil, 1, 16)
+
+	job := Job{Source: "docker.io/library/busybox:latest", Target: "harbor.example.com/tars/library/busybox:latest"}
+
+	// Enqueue the same target several times while the first copy is still blocked in Copy; the
+	// pending-dedup map should drop every one of these instead of queuing a second worker run.
+	for i := 0; i < 5; i++ {
+		q.Enqueue(job)
+	}
+
+	close(copier.unblock)
+
+	waitFor(t, func() bool { return copier.callCount(job.Target) > 0 })
+
+	// Give any (incorrectly) duplicated jobs a chance to land before asserting the count.
+	time.Sleep(50 * time.Millisecond)
+
+	if got := copier.callCount(job.Target); got != 1 {
+		t.Errorf("expected exactly 1 copy for a deduped target, got %d", got)
+	}
+}
+
+func TestQueueSkipsCopyWhenStoreAlreadyHasADigest(t *testing.T) {
+	copier := newFakeCopier()
+	store := newFakeStore()
+
+	job := Job{Source: "docker.io/library/busybox:latest", Target: "harbor.example.com/tars/library/busybox:latest"}
+	store.digests[job.Target] = "sha256:deadbeef"
+
+	q := NewQueue(copier, store, 1, 16)
+	q.Enqueue(job)
+
+	// The job should be dropped from pending almost immediately since Queue.run returns early on
+	// a cache hit; poll for that instead of asserting on a fixed sleep.
+	waitFor(t, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return !q.pending[job.Target]
+	})
+
+	if got := copier.callCount(job.Target); got != 0 {
+		t.Errorf("expected no copy for an already-cached target, got %d calls", got)
+	}
+}
+
+func TestRunStrictReturnsCopyErrorWithoutWaitingForManifest(t *testing.T) {
+	copyErr := fmt.Errorf("boom")
+	copier := newFakeCopier()
+	copier.err = copyErr
+
+	job := Job{Source: "docker.io/library/busybox:latest", Target: "harbor.example.com/tars/library/busybox:latest"}
+
+	done := make(chan error, 1)
+	go func() { done <- RunStrict(copier, job, time.Second) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected RunStrict to return an error when the copy itself fails")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("RunStrict did not return promptly on a copy failure; it should not fall through to polling the manifest")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !cond() {
+		t.Fatal("condition was not met within the timeout")
+	}
+}
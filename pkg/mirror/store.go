@@ -0,0 +1,141 @@
+// Copyright Project Harbor Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// cachedImageGVR identifies the CachedImage CRD (tars.goharbor.io/v1alpha1) the webhook records
+// mirror status in, one object per rewritten target reference.
+var cachedImageGVR = schema.GroupVersionResource{
+	Group:    "tars.goharbor.io",
+	Version:  "v1alpha1",
+	Resource: "cachedimages",
+}
+
+// cachedImageNamespace is where CachedImage objects live; they describe a registry-wide cache
+// entry, not anything namespace-scoped, so they all go in one well-known namespace rather than
+// being duplicated per workload namespace.
+const cachedImageNamespace = "tars-system"
+
+// CRDStore persists mirror status as CachedImage custom resources via the dynamic client, so the
+// controller doesn't re-copy an image it already mirrored on a previous admission.
+type CRDStore struct {
+	client dynamic.Interface
+}
+
+// NewCRDStore returns a Store backed by the CachedImage CRD, reachable through client.
+func NewCRDStore(client dynamic.Interface) *CRDStore {
+	return &CRDStore{client: client}
+}
+
+// cachedImageName derives a CRD-safe object name from a target reference, since reference
+// strings routinely contain characters ("/", ":", "@") that aren't valid Kubernetes object
+// names.
+func cachedImageName(target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return "img-" + hex.EncodeToString(sum[:])[:40]
+}
+
+// Get reports the digest last recorded for target, and whether that copy succeeded.
+func (s *CRDStore) Get(target string) (string, bool, error) {
+	obj, err := s.client.Resource(cachedImageGVR).Namespace(cachedImageNamespace).
+		Get(context.Background(), cachedImageName(target), metav1.GetOptions{})
+	if err != nil {
+		return "", false, nil
+	}
+
+	status, found, _ := unstructured.NestedMap(obj.Object, "status")
+	if !found {
+		return "", false, nil
+	}
+
+	if ok, _ := status["lastCopySucceeded"].(bool); !ok {
+		return "", false, nil
+	}
+
+	digest, _ := status["digest"].(string)
+	return digest, true, nil
+}
+
+// Save records the outcome of a copy attempt for target as a CachedImage, creating the object on
+// first write and updating it on every subsequent one. status is a real subresource on the
+// CachedImage CRD (deploy/cachedimage-crd.yaml), so it's always written back through
+// UpdateStatus rather than the plain object Update the main /status-less endpoint would ignore.
+func (s *CRDStore) Save(target, digest string, copyErr error) error {
+	ctx := context.Background()
+	name := cachedImageName(target)
+	client := s.client.Resource(cachedImageGVR).Namespace(cachedImageNamespace)
+
+	status := map[string]interface{}{
+		"target":            target,
+		"digest":            digest,
+		"lastCopied":        time.Now().UTC().Format(time.RFC3339),
+		"lastCopySucceeded": copyErr == nil,
+	}
+	if copyErr != nil {
+		status["lastError"] = copyErr.Error()
+	}
+
+	existing, err := client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		obj := &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": cachedImageGVR.GroupVersion().String(),
+				"kind":       "CachedImage",
+				"metadata": map[string]interface{}{
+					"name":      name,
+					"namespace": cachedImageNamespace,
+				},
+				"spec": map[string]interface{}{
+					"target": target,
+				},
+			},
+		}
+
+		created, err := client.Create(ctx, obj, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("could not create CachedImage %s: %v", name, err)
+		}
+
+		if err := unstructured.SetNestedMap(created.Object, status, "status"); err != nil {
+			return err
+		}
+		if _, err := client.UpdateStatus(ctx, created, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("could not set initial status of CachedImage %s: %v", name, err)
+		}
+		return nil
+	}
+
+	if err := unstructured.SetNestedMap(existing.Object, status, "status"); err != nil {
+		return err
+	}
+
+	if _, err := client.UpdateStatus(ctx, existing, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("could not update CachedImage %s status: %v", name, err)
+	}
+
+	return nil
+}